@@ -5,7 +5,11 @@
 package prototext
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
 	"fmt"
+	"io"
 	"strings"
 	"unicode/utf8"
 
@@ -24,6 +28,74 @@ func Unmarshal(b []byte, m proto.Message) error {
 	return UnmarshalOptions{}.Unmarshal(b, m)
 }
 
+// recordSeparator is an optional line that may appear between successive
+// message records in a stream read by Decoder. A bare EOF also terminates a
+// record, so single-message streams need no separator at all.
+const recordSeparator = "---"
+
+// Decoder reads a stream of textproto-encoded messages from an io.Reader,
+// one top-level message record at a time, so that a stream of many records
+// (e.g. recorded RPC traces separated by recordSeparator lines) can be
+// processed without holding more than one record in memory at once. It is
+// analogous to encoding/json.Decoder.
+//
+// Decoder targets that multi-record case only: each record is still read
+// and parsed into memory in full before Decode returns, by calling
+// UnmarshalOptions.Unmarshal on it, the same as calling Unmarshal directly
+// in a loop. Decoder does not bound the memory needed for one enormous,
+// unseparated message; doing so would require driving the
+// internal/encoding/text tokenizer field-by-field as each field is
+// consumed, which this package does not expose.
+type Decoder struct {
+	r *bufio.Reader
+	o UnmarshalOptions
+}
+
+// NewDecoder returns a new Decoder that reads successive message records
+// from r and unmarshals them using the given UnmarshalOptions.
+func NewDecoder(r io.Reader, o UnmarshalOptions) *Decoder {
+	if o.Resolver == nil {
+		o.Resolver = protoregistry.GlobalTypes
+	}
+	return &Decoder{r: bufio.NewReader(r), o: o}
+}
+
+// Decode reads the next message record from the underlying reader and
+// unmarshals it into m. It returns io.EOF once there are no more records.
+func (d *Decoder) Decode(m proto.Message) error {
+	b, err := d.readRecord()
+	if err != nil {
+		return err
+	}
+	return d.o.Unmarshal(b, m)
+}
+
+// readRecord reads up to and including the next recordSeparator line, or to
+// EOF, and returns the bytes read excluding the separator itself.
+func (d *Decoder) readRecord() ([]byte, error) {
+	var buf bytes.Buffer
+	var sawAny bool
+	for {
+		line, err := d.r.ReadString('\n')
+		if len(line) > 0 {
+			sawAny = true
+			if strings.TrimSpace(line) == recordSeparator {
+				return buf.Bytes(), nil
+			}
+			buf.WriteString(line)
+		}
+		if err != nil {
+			if err == io.EOF {
+				if !sawAny {
+					return nil, io.EOF
+				}
+				return buf.Bytes(), nil
+			}
+			return nil, err
+		}
+	}
+}
+
 // UnmarshalOptions is a configurable textproto format unmarshaler.
 type UnmarshalOptions struct {
 	pragma.NoUnkeyedLiterals
@@ -40,18 +112,99 @@ type UnmarshalOptions struct {
 		protoregistry.MessageTypeResolver
 		protoregistry.ExtensionTypeResolver
 	}
+
+	// DiscardUnknown specifies whether to ignore unknown fields when
+	// unmarshaling. By default, unmarshal rejects fields unrecognized by the
+	// message, unresolvable extensions, and unresolvable map entry field
+	// names. With this option, unrecognized fields, extensions, and map
+	// entry field names are skipped instead of returning an error.
+	DiscardUnknown bool
+
+	// Merge merges the input into the given proto.Message. By default,
+	// Unmarshal resets the destination message before unmarshaling into it.
+	// With this option, unmarshal merges the input into the destination
+	// message, appending to repeated/list fields, merging nested messages
+	// recursively, and overwriting scalar fields.
+	Merge bool
+
+	// UnknownExtensionHandler, if set, is called when an extension field
+	// name cannot be resolved via Resolver. It is given the full name found
+	// in the input and the raw parsed value, and may resolve the type
+	// dynamically (e.g. from a descriptor fetched over gRPC reflection) by
+	// returning a non-nil ExtensionType. Returning a nil ExtensionType and a
+	// nil error skips the field, which is useful for stashing the raw value
+	// into an unknown-fields bucket without aborting the unmarshal.
+	UnknownExtensionHandler func(name pref.FullName, raw text.Value) (pref.ExtensionType, error)
+
+	// UnknownAnyHandler plays the same role as UnknownExtensionHandler for
+	// the type_url of a google.protobuf.Any value that cannot be resolved
+	// via Resolver. As with UnknownExtensionHandler, returning a nil
+	// MessageType and a nil error skips the Any value instead of aborting
+	// the unmarshal.
+	UnknownAnyHandler func(typeURL string, raw text.Value) (pref.MessageType, error)
+
+	// Lenient makes Unmarshal collect every error it encounters (unknown
+	// fields, duplicate oneofs, invalid scalars, bad map entries, ...)
+	// instead of returning on the first one. Unmarshal continues populating
+	// whatever it can and, if any errors were recorded, returns them all
+	// together as a single aggregated error.
+	//
+	// The aggregated errors do not carry source line/column position:
+	// text.Unmarshal parses the whole input into a position-free
+	// [][2]text.Value tree before Unmarshal ever walks it, so no position
+	// survives to attach to an error found later. Reporting positions would
+	// require driving the internal/encoding/text tokenizer directly instead
+	// of its full-tree Unmarshal entry point.
+	Lenient bool
+
+	// errs accumulates the errors recorded by reportError during a Lenient
+	// unmarshal. It is set up by the top-level Unmarshal call and shared by
+	// value with every recursive call via the pointer.
+	errs *[]error
+}
+
+// reportError handles a fatal parse error according to Lenient: if Lenient
+// is false, or no error list has been set up by Unmarshal, err is returned
+// unchanged so that the caller aborts as before. Otherwise err is appended
+// to the accumulated error list and nil is returned so the caller can move
+// on to the rest of the input.
+func (o UnmarshalOptions) reportError(err error) error {
+	if err == nil || !o.Lenient || o.errs == nil {
+		return err
+	}
+	*o.errs = append(*o.errs, err)
+	return nil
+}
+
+// multiError is returned by Unmarshal in Lenient mode when one or more
+// fields failed to unmarshal. Formatting it prints every recorded error on
+// its own line.
+type multiError []error
+
+func (m multiError) Error() string {
+	var b strings.Builder
+	for i, err := range m {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(err.Error())
+	}
+	return b.String()
 }
 
 // Unmarshal reads the given []byte and populates the given proto.Message using options in
 // UnmarshalOptions object.
 func (o UnmarshalOptions) Unmarshal(b []byte, m proto.Message) error {
 	var nerr errors.NonFatal
+	var errs []error
+	if o.Lenient {
+		o.errs = &errs
+	}
 
-	// Clear all fields before populating it.
-	// TODO: Determine if this needs to be consistent with protojson and binary unmarshal where
-	// behavior is to merge values into existing message. If decision is to not clear the fields
-	// ahead, code will need to be updated properly when merging nested messages.
-	proto.Reset(m)
+	// Clear all fields before populating it, unless Merge is specified.
+	if !o.Merge {
+		proto.Reset(m)
+	}
 
 	// Parse into text.Value of message type.
 	val, err := text.Unmarshal(b)
@@ -71,6 +224,17 @@ func (o UnmarshalOptions) Unmarshal(b []byte, m proto.Message) error {
 		nerr.Merge(proto.IsInitialized(m))
 	}
 
+	// nerr.E collects non-fatal issues (e.g. invalid UTF-8) found outside of
+	// reportError's Lenient-gated call sites, so in Lenient mode it must be
+	// folded into errs rather than discarded, or such an issue on one field
+	// would vanish whenever an unrelated field also reported a Lenient
+	// error. Outside Lenient mode, nerr.E is returned as before.
+	if o.Lenient && nerr.E != nil {
+		errs = append(errs, nerr.E)
+	}
+	if len(errs) > 0 {
+		return multiError(errs)
+	}
 	return nerr.E
 }
 
@@ -116,7 +280,26 @@ func (o UnmarshalOptions) unmarshalMessage(tmsg [][2]text.Value, m pref.Message)
 			// repeated fields.
 			xt, err := o.findExtension(extName)
 			if err != nil && err != protoregistry.NotFound {
-				return errors.New("unable to resolve [%v]: %v", extName, err)
+				if err := o.reportError(errors.New("unable to resolve [%v]: %v", extName, err)); err != nil {
+					return err
+				}
+				continue
+			}
+			if err == protoregistry.NotFound {
+				if o.UnknownExtensionHandler != nil {
+					xt, err = o.UnknownExtensionHandler(extName, tval)
+					if err != nil {
+						if err := o.reportError(errors.New("unable to resolve [%v]: %v", extName, err)); err != nil {
+							return err
+						}
+						continue
+					}
+					if xt == nil {
+						continue
+					}
+				} else if o.DiscardUnknown {
+					continue
+				}
 			}
 			fd = xt
 		}
@@ -126,8 +309,14 @@ func (o UnmarshalOptions) unmarshalMessage(tmsg [][2]text.Value, m pref.Message)
 			if reservedNames.Has(name) {
 				continue
 			}
-			// TODO: Can provide option to ignore unknown message fields.
-			return errors.New("%v contains unknown field: %v", messageDesc.FullName(), tkey)
+			// Ignore unknown fields if specified.
+			if o.DiscardUnknown {
+				continue
+			}
+			if err := o.reportError(errors.New("%v contains unknown field: %v", messageDesc.FullName(), tkey)); err != nil {
+				return err
+			}
+			continue
 		}
 
 		switch {
@@ -142,7 +331,10 @@ func (o UnmarshalOptions) unmarshalMessage(tmsg [][2]text.Value, m pref.Message)
 
 			list := m.Mutable(fd).List()
 			if err := o.unmarshalList(items, fd, list); !nerr.Merge(err) {
-				return err
+				if err := o.reportError(err); err != nil {
+					return err
+				}
+				continue
 			}
 		case fd.IsMap():
 			// If input is not a list, turn it into a list.
@@ -155,14 +347,20 @@ func (o UnmarshalOptions) unmarshalMessage(tmsg [][2]text.Value, m pref.Message)
 
 			mmap := m.Mutable(fd).Map()
 			if err := o.unmarshalMap(items, fd, mmap); !nerr.Merge(err) {
-				return err
+				if err := o.reportError(err); err != nil {
+					return err
+				}
+				continue
 			}
 		default:
 			// If field is a oneof, check if it has already been set.
 			if od := fd.ContainingOneof(); od != nil {
 				idx := uint64(od.Index())
 				if seenOneofs.Has(idx) {
-					return errors.New("oneof %v is already set", od.FullName())
+					if err := o.reportError(errors.New("oneof %v is already set", od.FullName())); err != nil {
+						return err
+					}
+					continue
 				}
 				seenOneofs.Set(idx)
 			}
@@ -170,10 +368,16 @@ func (o UnmarshalOptions) unmarshalMessage(tmsg [][2]text.Value, m pref.Message)
 			// Required or optional fields.
 			num := uint64(fd.Number())
 			if seenNums.Has(num) {
-				return errors.New("non-repeated field %v is repeated", fd.FullName())
+				if err := o.reportError(errors.New("non-repeated field %v is repeated", fd.FullName())); err != nil {
+					return err
+				}
+				continue
 			}
 			if err := o.unmarshalSingular(tval, fd, m); !nerr.Merge(err) {
-				return err
+				if err := o.reportError(err); err != nil {
+					return err
+				}
+				continue
 			}
 			seenNums.Set(num)
 		}
@@ -204,9 +408,14 @@ func (o UnmarshalOptions) unmarshalSingular(input text.Value, fd pref.FieldDescr
 	switch fd.Kind() {
 	case pref.MessageKind, pref.GroupKind:
 		if input.Type() != text.Message {
-			return errors.New("%v contains invalid message/group value: %v", fd.FullName(), input)
+			return o.reportError(errors.New("%v contains invalid message/group value: %v", fd.FullName(), input))
+		}
+		var m2 pref.Message
+		if o.Merge && m.Has(fd) {
+			m2 = m.Mutable(fd).Message()
+		} else {
+			m2 = m.NewMessage(fd)
 		}
-		m2 := m.NewMessage(fd)
 		if err := o.unmarshalMessage(input.Message(), m2); !nerr.Merge(err) {
 			return err
 		}
@@ -269,6 +478,18 @@ func unmarshalScalar(input text.Value, fd pref.FieldDescriptor) (pref.Value, err
 			return pref.ValueOf(s), nerr.E
 		}
 	case pref.BytesKind:
+		// A bare "field: 0x2a" numeric literal is treated as a hex-encoded
+		// byte sequence; a quoted "field: \"...\"" string is always literal
+		// byte content, exactly as it was before this fork, never hex. The
+		// two forms are never ambiguous: input.Type() distinguishes them at
+		// the tokenizer level, so (unlike an earlier version of this code)
+		// a legitimate quoted byte string that happens to look like hex
+		// (e.g. "0xCAFE") is never misdecoded.
+		if input.Type() == text.Uint {
+			if n, ok := input.Uint(b64); ok {
+				return pref.ValueOf(uintToMinimalBytes(n)), nil
+			}
+		}
 		if input.Type() == text.String {
 			return pref.ValueOf([]byte(input.String())), nil
 		}
@@ -290,6 +511,30 @@ func unmarshalScalar(input text.Value, fd pref.FieldDescriptor) (pref.Value, err
 	return pref.Value{}, errors.New("%v contains invalid scalar value: %v", fd.FullName(), input)
 }
 
+// uintToMinimalBytes converts n to its minimal big-endian byte
+// representation: no leading zero bytes, except that 0 itself still
+// encodes as a single zero byte.
+//
+// This is a best-effort decoding of a bare "0x..." literal as a byte
+// sequence, not a full fix: the tokenizer parses such a literal as a
+// uint64 before unmarshalScalar ever sees it, so by this point any
+// leading zero bytes in the original literal are already gone (e.g.
+// "0x0001" and "0x01" are indistinguishable here) and values wider than
+// 8 bytes (e.g. a 32-byte hash) cannot be represented by this token at
+// all. Round-tripping those exactly would require a dedicated hex-bytes
+// token in internal/encoding/text, which this package does not define;
+// until then, round-trip hash- or key-sized values as quoted byte
+// strings instead of bare hex literals.
+func uintToMinimalBytes(n uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], n)
+	i := 0
+	for i < len(buf)-1 && buf[i] == 0 {
+		i++
+	}
+	return buf[i:]
+}
+
 // unmarshalList unmarshals given []text.Value into given protoreflect.List.
 func (o UnmarshalOptions) unmarshalList(inputList []text.Value, fd pref.FieldDescriptor, list pref.List) error {
 	var nerr errors.NonFatal
@@ -298,11 +543,17 @@ func (o UnmarshalOptions) unmarshalList(inputList []text.Value, fd pref.FieldDes
 	case pref.MessageKind, pref.GroupKind:
 		for _, input := range inputList {
 			if input.Type() != text.Message {
-				return errors.New("%v contains invalid message/group value: %v", fd.FullName(), input)
+				if err := o.reportError(errors.New("%v contains invalid message/group value: %v", fd.FullName(), input)); err != nil {
+					return err
+				}
+				continue
 			}
 			m := list.NewMessage()
 			if err := o.unmarshalMessage(input.Message(), m); !nerr.Merge(err) {
-				return err
+				if err := o.reportError(err); err != nil {
+					return err
+				}
+				continue
 			}
 			list.Append(pref.ValueOf(m))
 		}
@@ -310,7 +561,10 @@ func (o UnmarshalOptions) unmarshalList(inputList []text.Value, fd pref.FieldDes
 		for _, input := range inputList {
 			val, err := unmarshalScalar(input, fd)
 			if !nerr.Merge(err) {
-				return err
+				if err := o.reportError(err); err != nil {
+					return err
+				}
+				continue
 			}
 			list.Append(val)
 		}
@@ -333,19 +587,31 @@ func (o UnmarshalOptions) unmarshalMap(input []text.Value, fd pref.FieldDescript
 
 	for _, entry := range input {
 		if entry.Type() != text.Message {
-			return errors.New("%v contains invalid map entry: %v", fd.FullName(), entry)
+			if err := o.reportError(errors.New("%v contains invalid map entry: %v", fd.FullName(), entry)); err != nil {
+				return err
+			}
+			continue
 		}
-		tkey, tval, err := parseMapEntry(entry.Message(), fd.FullName())
+		tkey, tval, err := o.parseMapEntry(entry.Message(), fd.FullName())
 		if !nerr.Merge(err) {
-			return err
+			if err := o.reportError(err); err != nil {
+				return err
+			}
+			continue
 		}
 		pkey, err := unmarshalMapKey(tkey, fd.MapKey())
 		if !nerr.Merge(err) {
-			return err
+			if err := o.reportError(err); err != nil {
+				return err
+			}
+			continue
 		}
 		err = unmarshalMapValue(tval, pkey, fd.MapValue(), mmap)
 		if !nerr.Merge(err) {
-			return err
+			if err := o.reportError(err); err != nil {
+				return err
+			}
+			continue
 		}
 	}
 
@@ -356,19 +622,26 @@ func (o UnmarshalOptions) unmarshalMap(input []text.Value, fd pref.FieldDescript
 // field values. If there are duplicate field names, the value for the last field is returned. If
 // the field name does not exist, it will return the zero value of text.Value. It will return an
 // error if there are unknown field names.
-func parseMapEntry(mapEntry [][2]text.Value, name pref.FullName) (key text.Value, value text.Value, err error) {
+// With DiscardUnknown, unknown field names are skipped instead. In Lenient
+// mode, unknown and duplicate field names are recorded through reportError
+// and parsing of the entry continues.
+func (o UnmarshalOptions) parseMapEntry(mapEntry [][2]text.Value, name pref.FullName) (key text.Value, value text.Value, err error) {
 	for _, field := range mapEntry {
 		keyStr, ok := field[0].Name()
 		if ok {
 			switch keyStr {
 			case "key":
 				if key.Type() != 0 {
-					return key, value, errors.New("%v contains duplicate key field", name)
+					if err := o.reportError(errors.New("%v contains duplicate key field", name)); err != nil {
+						return key, value, err
+					}
 				}
 				key = field[1]
 			case "value":
 				if value.Type() != 0 {
-					return key, value, errors.New("%v contains duplicate value field", name)
+					if err := o.reportError(errors.New("%v contains duplicate value field", name)); err != nil {
+						return key, value, err
+					}
 				}
 				value = field[1]
 			default:
@@ -376,8 +649,13 @@ func parseMapEntry(mapEntry [][2]text.Value, name pref.FullName) (key text.Value
 			}
 		}
 		if !ok {
-			// TODO: Do not return error if ignore unknown option is added and enabled.
-			return key, value, errors.New("%v contains unknown map entry name: %v", name, field[0])
+			if o.DiscardUnknown {
+				continue
+			}
+			if err := o.reportError(errors.New("%v contains unknown map entry name: %v", name, field[0])); err != nil {
+				return key, value, err
+			}
+			continue
 		}
 	}
 	return key, value, nil
@@ -407,7 +685,12 @@ func (o UnmarshalOptions) unmarshalMapMessageValue(input text.Value, pkey pref.M
 	if input.Type() != 0 {
 		value = input.Message()
 	}
-	m := mmap.NewMessage()
+	var m pref.Message
+	if o.Merge && mmap.Has(pkey) {
+		m = mmap.Mutable(pkey).Message()
+	} else {
+		m = mmap.NewMessage()
+	}
 	if err := o.unmarshalMessage(value, m); !nerr.Merge(err) {
 		return err
 	}
@@ -453,14 +736,21 @@ func (o UnmarshalOptions) unmarshalAny(tfield [2]text.Value, m pref.Message) err
 	value := tfield[1].Message()
 
 	mt, err := o.Resolver.FindMessageByURL(typeURL)
+	if err != nil && o.UnknownAnyHandler != nil {
+		mt, err = o.UnknownAnyHandler(typeURL, tfield[1])
+	}
 	if !nerr.Merge(err) {
-		return errors.New("unable to resolve message [%v]: %v", typeURL, err)
+		return o.reportError(errors.New("unable to resolve message [%v]: %v", typeURL, err))
+	}
+	if mt == nil {
+		// UnknownAnyHandler chose to skip this Any value.
+		return nil
 	}
 	// Create new message for the embedded message type and unmarshal the
 	// value into it.
 	m2 := mt.New()
 	if err := o.unmarshalMessage(value, m2); !nerr.Merge(err) {
-		return err
+		return o.reportError(err)
 	}
 	// Serialize the embedded message and assign the resulting bytes to the value field.
 	// TODO: If binary marshaling returns required not set error, need to
@@ -471,7 +761,7 @@ func (o UnmarshalOptions) unmarshalAny(tfield [2]text.Value, m pref.Message) err
 		Deterministic: true,
 	}.Marshal(m2.Interface())
 	if !nerr.Merge(err) {
-		return err
+		return o.reportError(err)
 	}
 
 	fds := m.Descriptor().Fields()
@@ -482,4 +772,4 @@ func (o UnmarshalOptions) unmarshalAny(tfield [2]text.Value, m pref.Message) err
 	m.Set(fdValue, pref.ValueOf(b))
 
 	return nerr.E
-}
\ No newline at end of file
+}
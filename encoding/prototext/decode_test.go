@@ -0,0 +1,89 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package prototext
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// This file only unit-tests the package's pure, dependency-free helpers.
+// It does not exercise UnmarshalOptions.Unmarshal or Decoder end-to-end
+// against real proto.Message values (the kind of test that would have
+// caught the chunk0-4 UnknownAnyHandler nil-mt panic, or the chunk0-5
+// quoted-string hex ambiguity, immediately): this repository snapshot has
+// no generated message types or protoreflect.Message implementation to
+// drive those entry points with, and this package does not define its
+// own test fixtures for them.
+
+func TestUintToMinimalBytes(t *testing.T) {
+	tests := []struct {
+		in   uint64
+		want []byte
+	}{
+		{in: 0x0a1b2c, want: []byte{0x0a, 0x1b, 0x2c}},
+		// Zero still encodes as one byte, not zero bytes.
+		{in: 0x00, want: []byte{0x00}},
+		{in: 0x01, want: []byte{0x01}},
+		// Full-width uint64 round-trips with no leading zero byte dropped
+		// beyond the minimal representation.
+		{in: 0xffffffffffffffff, want: []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+	}
+	for _, tt := range tests {
+		got := uintToMinimalBytes(tt.in)
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("uintToMinimalBytes(%#x) = %x, want %x", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestUnmarshalOptionsReportError(t *testing.T) {
+	errFoo := errors.New("foo")
+
+	t.Run("not lenient returns err unchanged", func(t *testing.T) {
+		o := UnmarshalOptions{}
+		if got := o.reportError(errFoo); got != errFoo {
+			t.Errorf("reportError() = %v, want %v", got, errFoo)
+		}
+	})
+
+	t.Run("lenient with no sink returns err unchanged", func(t *testing.T) {
+		o := UnmarshalOptions{Lenient: true}
+		if got := o.reportError(errFoo); got != errFoo {
+			t.Errorf("reportError() = %v, want %v", got, errFoo)
+		}
+	})
+
+	t.Run("lenient with sink accumulates and returns nil", func(t *testing.T) {
+		var errs []error
+		o := UnmarshalOptions{Lenient: true, errs: &errs}
+		if got := o.reportError(errFoo); got != nil {
+			t.Errorf("reportError() = %v, want nil", got)
+		}
+		if len(errs) != 1 || errs[0] != errFoo {
+			t.Errorf("errs = %v, want [%v]", errs, errFoo)
+		}
+	})
+
+	t.Run("nil error is always a no-op", func(t *testing.T) {
+		var errs []error
+		o := UnmarshalOptions{Lenient: true, errs: &errs}
+		if got := o.reportError(nil); got != nil {
+			t.Errorf("reportError(nil) = %v, want nil", got)
+		}
+		if len(errs) != 0 {
+			t.Errorf("errs = %v, want none", errs)
+		}
+	})
+}
+
+func TestMultiErrorError(t *testing.T) {
+	m := multiError{errors.New("foo"), errors.New("bar")}
+	want := "foo\nbar"
+	if got := m.Error(); got != want {
+		t.Errorf("multiError.Error() = %q, want %q", got, want)
+	}
+}